@@ -0,0 +1,73 @@
+package qemu
+
+import (
+	"os"
+	"runtime"
+)
+
+// Options configures a Machine's target architecture and acceleration
+// for Run, with every field defaulted from the host when left empty
+type Options struct {
+	Arch        string // e.g. "x86_64"; defaults to DefaultArch()
+	Accel       string // e.g. "kvm:tcg"; defaults to DefaultAccel()
+	MachineType string // QEMU -machine type; defaults to DefaultMachineType(Arch)
+}
+
+// DefaultArch maps the host's runtime.GOARCH to the architecture name
+// QEMU expects on its "qemu-system-<arch>" binaries
+func DefaultArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "s390x":
+		return "s390x"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// DefaultMachineType picks the conventional QEMU machine type for arch,
+// since chipsets are not interchangeable across architectures (q35 is
+// an x86 PC chipset and qemu-system-aarch64 rejects it outright)
+func DefaultMachineType(arch string) string {
+	switch arch {
+	case "aarch64":
+		return "virt"
+	case "s390x":
+		return "s390-ccw-virtio"
+	default:
+		return "q35"
+	}
+}
+
+// DefaultAccel picks the best accelerator list for the host platform,
+// probing for actual KVM access on Linux rather than trusting a flag
+func DefaultAccel() string {
+	switch runtime.GOOS {
+	case "linux":
+		if hasKVM() {
+			return "kvm:tcg"
+		}
+		return "tcg"
+	case "darwin":
+		return "hvf:tcg"
+	case "windows":
+		return "whpx:tcg"
+	default:
+		return "tcg"
+	}
+}
+
+// hasKVM reports whether /dev/kvm can actually be opened, rather than
+// just assuming Linux implies KVM
+func hasKVM() bool {
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+
+	return true
+}
@@ -0,0 +1,23 @@
+package qemu
+
+import "testing"
+
+func TestDefaultMachineType(t *testing.T) {
+	cases := []struct {
+		arch string
+		want string
+	}{
+		{"aarch64", "virt"},
+		{"s390x", "s390-ccw-virtio"},
+		{"x86_64", "q35"},
+		{"unknown-arch", "q35"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.arch, func(t *testing.T) {
+			if got := DefaultMachineType(c.arch); got != c.want {
+				t.Errorf("DefaultMachineType(%q) = %q, want %q", c.arch, got, c.want)
+			}
+		})
+	}
+}
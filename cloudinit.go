@@ -0,0 +1,106 @@
+package qemu
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// AddCloudInit builds a NoCloud seed ISO labelled "cidata" containing the
+// given user-data, meta-data and (optionally empty) network-config, and
+// attaches it as an additional drive, so a cloud image can be provisioned
+// with credentials and networking purely through this API
+func (m *Machine) AddCloudInit(userData, metaData, networkConfig []byte) error {
+	dir, err := m.seedDir()
+	if err != nil {
+		return err
+	}
+
+	seed := filepath.Join(dir, "cidata.iso")
+	files := map[string][]byte{
+		"user-data": userData,
+		"meta-data": metaData,
+	}
+	if len(networkConfig) > 0 {
+		files["network-config"] = networkConfig
+	}
+
+	if err := writeISO(seed, "cidata", files); err != nil {
+		return err
+	}
+
+	return m.AddDrive(Drive{Path: seed, Format: "raw"})
+}
+
+// AddIgnition attaches an Ignition config to the machine via fw_cfg, the
+// mechanism CoreOS/Fedora CoreOS images read their provisioning config
+// from on both x86_64 and aarch64
+func (m *Machine) AddIgnition(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("ignition config: %s", err)
+	}
+
+	m.ignition = path
+
+	return nil
+}
+
+// seedDir lazily creates the temp directory used to hold generated seed
+// data for this machine, so repeated calls share one cleanup target
+func (m *Machine) seedDir() (string, error) {
+	if len(m.tmpDir) > 0 {
+		return m.tmpDir, nil
+	}
+
+	dir, err := os.MkdirTemp("", "go-qemu-seed")
+	if err != nil {
+		return "", fmt.Errorf("create seed dir: %s", err)
+	}
+
+	m.tmpDir = dir
+
+	return dir, nil
+}
+
+// writeISO lays out files in a scratch directory next to target and
+// builds a cidata-style ISO from them using whichever of genisoimage or
+// mkisofs is available on the host
+func writeISO(target, volid string, files map[string][]byte) error {
+	src := target + ".d"
+	if err := os.MkdirAll(src, 0700); err != nil {
+		return fmt.Errorf("create iso staging dir: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), data, 0600); err != nil {
+			return fmt.Errorf("write %s: %s", name, err)
+		}
+	}
+
+	tool, err := isoBuilder()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(tool, "-output", target, "-volid", volid, "-joliet", "-rock", src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", tool, err, out)
+	}
+
+	return nil
+}
+
+// isoBuilder returns the path to whichever ISO 9660 builder is
+// installed, preferring genisoimage as mkisofs is frequently a symlink
+// to it
+func isoBuilder() (string, error) {
+	for _, tool := range []string{"genisoimage", "mkisofs"} {
+		if path, err := exec.LookPath(tool); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("neither genisoimage nor mkisofs found in PATH")
+}
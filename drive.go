@@ -0,0 +1,106 @@
+package qemu
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+var validDriveIf = map[string]bool{
+	"virtio": true,
+	"scsi":   true,
+	"ide":    true,
+	"none":   true,
+}
+
+var validDriveCache = map[string]bool{
+	"none":         true,
+	"writeback":    true,
+	"writethrough": true,
+	"unsafe":       true,
+	"directsync":   true,
+}
+
+var validDriveDiscard = map[string]bool{
+	"unmap":  true,
+	"ignore": true,
+}
+
+// validate checks the drive's enumerated fields against the same
+// allow-lists Packer validates QEMU drive options against
+func (d Drive) validate() error {
+	if len(d.If) > 0 && !validDriveIf[d.If] {
+		return fmt.Errorf("drive: invalid if %q", d.If)
+	}
+
+	if len(d.Cache) > 0 && !validDriveCache[d.Cache] {
+		return fmt.Errorf("drive: invalid cache %q", d.Cache)
+	}
+
+	if len(d.Discard) > 0 && !validDriveDiscard[d.Discard] {
+		return fmt.Errorf("drive: invalid discard %q", d.Discard)
+	}
+
+	return nil
+}
+
+// arg renders the drive as a "-drive" argument, including every field
+// that has been set
+func (d Drive) arg() string {
+	s := fmt.Sprintf("file=%s,format=%s", d.Path, d.Format)
+
+	if len(d.id) > 0 {
+		s = fmt.Sprintf("%s,id=%s", s, d.id)
+	}
+
+	if len(d.If) > 0 {
+		s = fmt.Sprintf("%s,if=%s", s, d.If)
+	}
+
+	if len(d.Cache) > 0 {
+		s = fmt.Sprintf("%s,cache=%s", s, d.Cache)
+	}
+
+	if len(d.Discard) > 0 {
+		s = fmt.Sprintf("%s,discard=%s", s, d.Discard)
+	}
+
+	if d.ReadOnly {
+		s = fmt.Sprintf("%s,readonly=on", s)
+	}
+
+	if d.Snapshot {
+		s = fmt.Sprintf("%s,snapshot=on", s)
+	}
+
+	return s
+}
+
+// createImage shells out to qemu-img to create a fresh disk image of
+// the given size (in bytes) at path
+func createImage(path, format string, size uint64) error {
+	cmd := exec.Command("qemu-img", "create", "-f", format, path, strconv.FormatUint(size, 10))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("qemu-img create: %s: %s", err, out)
+	}
+
+	return nil
+}
+
+// AddSCSIDrive attaches d through a virtio-scsi controller: the drive
+// itself is rendered with if=none and paired with a "-device
+// scsi-hd,drive=...,bus=scsi0.0", while the controller's "-device
+// virtio-scsi-pci,id=scsi0" is added only once per Machine no matter
+// how many SCSI drives are added
+func (m *Machine) AddSCSIDrive(d Drive) error {
+	d.If = "none"
+	d.id = fmt.Sprintf("scsi%d", len(m.scsiDevices))
+
+	if err := m.AddDrive(d); err != nil {
+		return err
+	}
+
+	m.scsiDevices = append(m.scsiDevices, fmt.Sprintf("scsi-hd,drive=%s,bus=scsi0.0", d.id))
+
+	return nil
+}
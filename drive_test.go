@@ -0,0 +1,97 @@
+package qemu
+
+import "testing"
+
+func TestDriveArg(t *testing.T) {
+	cases := []struct {
+		name string
+		d    Drive
+		want string
+	}{
+		{
+			name: "minimal",
+			d:    Drive{Path: "/tmp/disk.qcow2", Format: "qcow2"},
+			want: "file=/tmp/disk.qcow2,format=qcow2",
+		},
+		{
+			name: "every field set",
+			d: Drive{
+				Path:     "/tmp/disk.qcow2",
+				Format:   "qcow2",
+				If:       "virtio",
+				Cache:    "none",
+				Discard:  "unmap",
+				ReadOnly: true,
+				Snapshot: true,
+				id:       "drive0",
+			},
+			want: "file=/tmp/disk.qcow2,format=qcow2,id=drive0,if=virtio,cache=none,discard=unmap,readonly=on,snapshot=on",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.d.arg(); got != c.want {
+				t.Errorf("arg() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDriveValidate(t *testing.T) {
+	valid := []Drive{
+		{If: "virtio"},
+		{If: "scsi"},
+		{If: "ide"},
+		{If: "none"},
+		{Cache: "writeback"},
+		{Discard: "ignore"},
+		{},
+	}
+	for _, d := range valid {
+		if err := d.validate(); err != nil {
+			t.Errorf("validate() on %+v: unexpected error %s", d, err)
+		}
+	}
+
+	invalid := []Drive{
+		{If: "virtio-scsi"},
+		{Cache: "bogus"},
+		{Discard: "bogus"},
+	}
+	for _, d := range invalid {
+		if err := d.validate(); err == nil {
+			t.Errorf("validate() on %+v: expected error, got nil", d)
+		}
+	}
+}
+
+func TestAddSCSIDrivePairsController(t *testing.T) {
+	m := NewMachine(1, 512)
+
+	if err := m.AddSCSIDrive(Drive{Path: "/tmp/a.qcow2", Format: "qcow2"}); err != nil {
+		t.Fatalf("AddSCSIDrive() error = %s", err)
+	}
+	if err := m.AddSCSIDrive(Drive{Path: "/tmp/b.qcow2", Format: "qcow2"}); err != nil {
+		t.Fatalf("AddSCSIDrive() error = %s", err)
+	}
+
+	if len(m.drives) != 2 {
+		t.Fatalf("len(m.drives) = %d, want 2", len(m.drives))
+	}
+	for _, d := range m.drives {
+		if d.If != "none" {
+			t.Errorf("drive %+v: If = %q, want \"none\"", d, d.If)
+		}
+	}
+
+	want := []string{"scsi-hd,drive=scsi0,bus=scsi0.0", "scsi-hd,drive=scsi1,bus=scsi0.0"}
+	if len(m.scsiDevices) != len(want) {
+		t.Fatalf("scsiDevices = %v, want %v", m.scsiDevices, want)
+	}
+	for i, dev := range want {
+		if m.scsiDevices[i] != dev {
+			t.Errorf("scsiDevices[%d] = %q, want %q", i, m.scsiDevices[i], dev)
+		}
+	}
+}
@@ -3,28 +3,54 @@ package qemu
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
-	"syscall"
-	"time"
 )
 
 // Machine represents a QEMU virtual machine
 type Machine struct {
 	Cores  int    // Number of CPU cores
 	Memory uint64 // RAM quantity in megabytes
+	Arch   string // Target architecture, e.g. "x86_64"; set by Run
+	Accel  string // Accelerator list, e.g. "kvm:tcg"; set by Run
 
 	cd      string
 	vnc     string
 	monitor string
 	drives  []Drive
 	ifaces  []NetDev
+
+	ignition    string
+	tmpDir      string
+	scsiDevices []string // "-device" args pairing each SCSI drive to the virtio-scsi-pci controller
+
+	proc *os.Process
+	qmp  *QMPClient
+}
+
+// Image is a disk image on disk, ready to be attached to a Machine
+// as a Drive via AddDriveImage
+type Image struct {
+	Path   string // Image file path
+	Format string // Image format
 }
 
 // Drive represents a machine hard drive
 type Drive struct {
 	Path   string // Image file path
 	Format string // Image format
+
+	If       string // Drive interface: "virtio", "scsi", "ide" or "none"
+	Cache    string // Cache mode: "none", "writeback", "writethrough", "unsafe" or "directsync"
+	Discard  string // Discard mode: "unmap" or "ignore"
+	ReadOnly bool   // Attach the drive read-only
+	Snapshot bool   // Discard writes to the drive on exit
+
+	// Size, when non-zero and Path does not already exist, causes a
+	// fresh image of Size bytes to be created via qemu-img before the
+	// machine starts
+	Size uint64
+
+	id string // "-drive" id, set internally for drives that need a paired "-device" (e.g. AddSCSIDrive)
 }
 
 // NewMachine creates a new virtual machine
@@ -44,16 +70,31 @@ func (m *Machine) AddCDRom(dev string) {
 	m.cd = dev
 }
 
-// AddDrive attaches a new hard drive to
-// the virtual machine
-func (m *Machine) AddDrive(d Drive) {
+// AddDrive attaches a new hard drive to the virtual machine, creating
+// a fresh image at d.Path first if d.Size is set and no file exists
+// there yet
+func (m *Machine) AddDrive(d Drive) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+
+	if d.Size > 0 {
+		if _, err := os.Stat(d.Path); os.IsNotExist(err) {
+			if err := createImage(d.Path, d.Format, d.Size); err != nil {
+				return err
+			}
+		}
+	}
+
 	m.drives = append(m.drives, d)
+
+	return nil
 }
 
 // AddDriveImage attaches the specified Image to
 // the virtual machine
-func (m *Machine) AddDriveImage(img Image) {
-	m.drives = append(m.drives, Drive{img.Path, img.Format})
+func (m *Machine) AddDriveImage(img Image) error {
+	return m.AddDrive(Drive{Path: img.Path, Format: img.Format})
 }
 
 // AddNetworkDevice attaches the specified netdev tp
@@ -69,22 +110,41 @@ func (m *Machine) AddVNC(addr string, port int) {
 }
 
 // AddMonitor redirects the QEMU monitor
-// to the specified unix socket file
+// to the specified unix socket file, which can later be reached
+// through Monitor once the machine has been started
 func (m *Machine) AddMonitorUnix(dev string) {
 	m.monitor = dev
 }
 
-// Start stars the machine
-// The 'kvm' bool specifies if KVM should be used
-// It returns the QEMU process and an error (if any)
-func (m *Machine) Start(arch string, kvm bool) (*os.Process, error) {
-	qemu := fmt.Sprintf("qemu-system-%s", arch)
-	args := []string{"-smp", strconv.Itoa(m.Cores), "-m", strconv.FormatUint(m.Memory, 10)}
+// buildArgs resolves opts against the host defaults, records the
+// resolved Arch/Accel on the machine, and renders every device that
+// does not depend on how the caller wants to run the process (serial
+// console, nographic, ...), returning the qemu-system binary to exec
+// and its base argument list
+func (m *Machine) buildArgs(opts Options) (string, []string) {
+	arch := opts.Arch
+	if len(arch) == 0 {
+		arch = DefaultArch()
+	}
+
+	accel := opts.Accel
+	if len(accel) == 0 {
+		accel = DefaultAccel()
+	}
 
-	if kvm {
-		args = append(args, "-enable-kvm")
+	machineType := opts.MachineType
+	if len(machineType) == 0 {
+		machineType = DefaultMachineType(arch)
 	}
 
+	m.Arch = arch
+	m.Accel = accel
+
+	qemu := fmt.Sprintf("qemu-system-%s", arch)
+	args := []string{"-smp", strconv.Itoa(m.Cores), "-m", strconv.FormatUint(m.Memory, 10)}
+	args = append(args, "-accel", accel)
+	args = append(args, "-machine", fmt.Sprintf("type=%s", machineType))
+
 	if len(m.cd) > 0 {
 		args = append(args, "-cdrom")
 		args = append(args, m.cd)
@@ -92,7 +152,17 @@ func (m *Machine) Start(arch string, kvm bool) (*os.Process, error) {
 
 	for _, drive := range m.drives {
 		args = append(args, "-drive")
-		args = append(args, fmt.Sprintf("file=%s,format=%s", drive.Path, drive.Format))
+		args = append(args, drive.arg())
+	}
+
+	if len(m.scsiDevices) > 0 {
+		args = append(args, "-device")
+		args = append(args, "virtio-scsi-pci,id=scsi0")
+
+		for _, dev := range m.scsiDevices {
+			args = append(args, "-device")
+			args = append(args, dev)
+		}
 	}
 
 	if len(m.ifaces) == 0 {
@@ -101,21 +171,11 @@ func (m *Machine) Start(arch string, kvm bool) (*os.Process, error) {
 	}
 
 	for _, iface := range m.ifaces {
-		s := fmt.Sprintf("%s,id=%s", iface.Type, iface.ID)
-		if len(iface.IfName) > 0 {
-			s = fmt.Sprintf("%s,ifname=%s", s, iface.IfName)
-		}
-
 		args = append(args, "-netdev")
-		args = append(args, s)
-
-		s = fmt.Sprintf("virtio-net,netdev=%s", iface.ID)
-		if len(iface.MAC) > 0 {
-			s = fmt.Sprintf("%s,mac=%s", s, iface.MAC)
-		}
+		args = append(args, iface.netdevString())
 
 		args = append(args, "-device")
-		args = append(args, s)
+		args = append(args, iface.deviceString())
 	}
 
 	if len(m.vnc) > 0 {
@@ -128,37 +188,10 @@ func (m *Machine) Start(arch string, kvm bool) (*os.Process, error) {
 		args = append(args, fmt.Sprintf("unix:%s,server,nowait", m.monitor))
 	}
 
-	cmd := exec.Command(qemu, args...)
-	cmd.SysProcAttr = new(syscall.SysProcAttr)
-	cmd.SysProcAttr.Setsid = true
-
-	err := cmd.Start()
-	if err != nil {
-		return nil, err
-	}
-
-	proc := cmd.Process
-	errc := make(chan error)
-
-	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			errc <- fmt.Errorf("'qemu-system-%s': %s", arch, err)
-			return
-		}
-	}()
-
-	time.Sleep(50 * time.Millisecond)
-
-	var vmerr error
-	select {
-	case vmerr = <-errc:
-		if vmerr != nil {
-			return nil, vmerr
-		}
-	default:
-		break
+	if len(m.ignition) > 0 {
+		args = append(args, "-fw_cfg")
+		args = append(args, fmt.Sprintf("name=opt/com.coreos/config,file=%s", m.ignition))
 	}
 
-	return proc, nil
+	return qemu, args
 }
@@ -0,0 +1,180 @@
+package qemu
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+)
+
+// NetDev is a QEMU network backend that knows how to render itself as
+// a "-netdev" argument and the paired "-device" argument that attaches
+// a NIC to it
+type NetDev interface {
+	netdevString() string
+	deviceString() string
+}
+
+// nic holds the fields common to every NetDev implementation: the
+// backend id referenced by the device, an optional MAC, and the QEMU
+// device model to emit (defaulting to virtio-net-pci)
+type nic struct {
+	ID     string
+	MAC    string
+	Device string // e.g. "virtio-net-pci", "e1000", "rtl8139", "vmxnet3"
+}
+
+func (n nic) deviceString() string {
+	model := n.Device
+	if len(model) == 0 {
+		model = "virtio-net-pci"
+	}
+
+	s := fmt.Sprintf("%s,netdev=%s", model, n.ID)
+	if len(n.MAC) > 0 {
+		s = fmt.Sprintf("%s,mac=%s", s, n.MAC)
+	}
+
+	return s
+}
+
+// PortForward describes a single user-mode hostfwd rule, redirecting a
+// host port to a guest port
+type PortForward struct {
+	Proto     string // "tcp" or "udp"
+	HostIP    string // defaults to 0.0.0.0 when empty
+	HostPort  int
+	GuestPort int
+}
+
+func (p PortForward) String() string {
+	proto := p.Proto
+	if len(proto) == 0 {
+		proto = "tcp"
+	}
+
+	hostIP := p.HostIP
+	if len(hostIP) == 0 {
+		hostIP = "0.0.0.0"
+	}
+
+	return fmt.Sprintf("%s:%s:%d-:%d", proto, hostIP, p.HostPort, p.GuestPort)
+}
+
+// UserNet is QEMU's user-mode (SLIRP) network backend, the only mode
+// that needs no host privileges and supports hostfwd port forwarding
+type UserNet struct {
+	nic
+	Hostfwd  []PortForward
+	Restrict bool
+	DNS      string
+}
+
+func (u UserNet) netdevString() string {
+	s := fmt.Sprintf("user,id=%s", u.ID)
+
+	if u.Restrict {
+		s = fmt.Sprintf("%s,restrict=on", s)
+	}
+
+	if len(u.DNS) > 0 {
+		s = fmt.Sprintf("%s,dns=%s", s, u.DNS)
+	}
+
+	for _, fwd := range u.Hostfwd {
+		s = fmt.Sprintf("%s,hostfwd=%s", s, fwd)
+	}
+
+	return s
+}
+
+// TapNet attaches to a host tap device, either driven by up/down
+// scripts or, when Bridge is set and no script is given, joined to the
+// bridge directly once QEMU has created the tap
+type TapNet struct {
+	nic
+	IfName     string
+	Script     string
+	DownScript string
+	Bridge     string
+}
+
+func (t TapNet) netdevString() string {
+	s := fmt.Sprintf("tap,id=%s", t.ID)
+
+	if len(t.IfName) > 0 {
+		s = fmt.Sprintf("%s,ifname=%s", s, t.IfName)
+	}
+
+	if len(t.Script) > 0 {
+		s = fmt.Sprintf("%s,script=%s", s, t.Script)
+	} else {
+		s = fmt.Sprintf("%s,script=no", s)
+	}
+
+	if len(t.DownScript) > 0 {
+		s = fmt.Sprintf("%s,downscript=%s", s, t.DownScript)
+	} else {
+		s = fmt.Sprintf("%s,downscript=no", s)
+	}
+
+	return s
+}
+
+// attachBridge joins the tap interface to Bridge once QEMU has created
+// it, used when no Script is given
+func (t TapNet) attachBridge() error {
+	if len(t.Bridge) == 0 || len(t.Script) > 0 {
+		return nil
+	}
+
+	cmd := exec.Command("ip", "link", "set", "dev", t.IfName, "master", t.Bridge)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("attach %s to bridge %s: %s: %s", t.IfName, t.Bridge, err, out)
+	}
+
+	return nil
+}
+
+// BridgeNet attaches directly to a host bridge through QEMU's
+// privileged bridge helper, without the caller managing a tap device
+type BridgeNet struct {
+	nic
+	Br string
+}
+
+func (b BridgeNet) netdevString() string {
+	return fmt.Sprintf("bridge,id=%s,br=%s", b.ID, b.Br)
+}
+
+// SocketNet backs a netdev with a raw TCP socket, either listening for
+// a peer or connecting out to one, useful for connecting two QEMU
+// instances directly
+type SocketNet struct {
+	nic
+	Listen  string
+	Connect string
+}
+
+func (s SocketNet) netdevString() string {
+	switch {
+	case len(s.Listen) > 0:
+		return fmt.Sprintf("socket,id=%s,listen=%s", s.ID, s.Listen)
+	case len(s.Connect) > 0:
+		return fmt.Sprintf("socket,id=%s,connect=%s", s.ID, s.Connect)
+	default:
+		return fmt.Sprintf("socket,id=%s", s.ID)
+	}
+}
+
+// AllocateHostPort grabs a free TCP port on the host by briefly
+// listening on :0, for callers that want an ephemeral hostfwd target
+// such as SSH port forwarding
+func AllocateHostPort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, fmt.Errorf("allocate host port: %s", err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
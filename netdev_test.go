@@ -0,0 +1,145 @@
+package qemu
+
+import "testing"
+
+func TestPortForwardString(t *testing.T) {
+	cases := []struct {
+		name string
+		pf   PortForward
+		want string
+	}{
+		{
+			name: "defaults",
+			pf:   PortForward{HostPort: 2222, GuestPort: 22},
+			want: "tcp:0.0.0.0:2222-:22",
+		},
+		{
+			name: "explicit proto and host ip",
+			pf:   PortForward{Proto: "udp", HostIP: "127.0.0.1", HostPort: 5353, GuestPort: 53},
+			want: "udp:127.0.0.1:5353-:53",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.pf.String(); got != c.want {
+				t.Errorf("String() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNicDeviceString(t *testing.T) {
+	cases := []struct {
+		name string
+		nic  nic
+		want string
+	}{
+		{
+			name: "default model",
+			nic:  nic{ID: "net0"},
+			want: "virtio-net-pci,netdev=net0",
+		},
+		{
+			name: "model and mac",
+			nic:  nic{ID: "net0", MAC: "52:54:00:00:00:01", Device: "e1000"},
+			want: "e1000,netdev=net0,mac=52:54:00:00:00:01",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.nic.deviceString(); got != c.want {
+				t.Errorf("deviceString() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestUserNetString(t *testing.T) {
+	u := UserNet{
+		nic:      nic{ID: "net0"},
+		Restrict: true,
+		DNS:      "10.0.2.3",
+		Hostfwd: []PortForward{
+			{HostPort: 2222, GuestPort: 22},
+		},
+	}
+
+	want := "user,id=net0,restrict=on,dns=10.0.2.3,hostfwd=tcp:0.0.0.0:2222-:22"
+	if got := u.netdevString(); got != want {
+		t.Errorf("netdevString() = %q, want %q", got, want)
+	}
+}
+
+func TestTapNetString(t *testing.T) {
+	cases := []struct {
+		name string
+		tap  TapNet
+		want string
+	}{
+		{
+			name: "no script, no bridge",
+			tap:  TapNet{nic: nic{ID: "net0"}, IfName: "tap0"},
+			want: "tap,id=net0,ifname=tap0,script=no,downscript=no",
+		},
+		{
+			name: "explicit scripts",
+			tap:  TapNet{nic: nic{ID: "net0"}, IfName: "tap0", Script: "up.sh", DownScript: "down.sh"},
+			want: "tap,id=net0,ifname=tap0,script=up.sh,downscript=down.sh",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.tap.netdevString(); got != c.want {
+				t.Errorf("netdevString() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBridgeNetString(t *testing.T) {
+	b := BridgeNet{nic: nic{ID: "net0"}, Br: "br0"}
+	want := "bridge,id=net0,br=br0"
+	if got := b.netdevString(); got != want {
+		t.Errorf("netdevString() = %q, want %q", got, want)
+	}
+}
+
+func TestSocketNetString(t *testing.T) {
+	cases := []struct {
+		name string
+		sock SocketNet
+		want string
+	}{
+		{
+			name: "listen",
+			sock: SocketNet{nic: nic{ID: "net0"}, Listen: ":1234"},
+			want: "socket,id=net0,listen=:1234",
+		},
+		{
+			name: "connect",
+			sock: SocketNet{nic: nic{ID: "net0"}, Connect: "10.0.0.1:1234"},
+			want: "socket,id=net0,connect=10.0.0.1:1234",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.sock.netdevString(); got != c.want {
+				t.Errorf("netdevString() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAllocateHostPort(t *testing.T) {
+	port, err := AllocateHostPort()
+	if err != nil {
+		t.Fatalf("AllocateHostPort() error = %s", err)
+	}
+	if port <= 0 {
+		t.Errorf("AllocateHostPort() = %d, want a positive port", port)
+	}
+}
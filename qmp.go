@@ -0,0 +1,369 @@
+package qemu
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Event is a QMP asynchronous event, such as SHUTDOWN or RESET,
+// emitted by the guest independently of any command/response exchange
+type Event struct {
+	Name      string                 `json:"event"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp struct {
+		Seconds      int64 `json:"seconds"`
+		Microseconds int64 `json:"microseconds"`
+	} `json:"timestamp"`
+}
+
+// qmpCommand is the envelope sent to QEMU for every QMP request
+type qmpCommand struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	ID        uint64      `json:"id"`
+}
+
+// qmpResponse is the envelope QEMU replies with for a command,
+// correlated back to the request via ID
+type qmpResponse struct {
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error,omitempty"`
+	ID uint64 `json:"id"`
+}
+
+// QMPClient is a connection to a QEMU monitor socket speaking the
+// QMP (QEMU Machine Protocol) JSON wire format
+type QMPClient struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	nextID  uint64
+	pending map[uint64]chan qmpResponse
+
+	// events is the channel returned by Events(); it is one of
+	// eventSubs, so every event is broadcast to it like any other
+	// subscriber
+	events    chan Event
+	eventMu   sync.Mutex
+	eventSubs []chan Event
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// DialQMP connects to a QMP socket at addr over the given network
+// (typically "unix"), performs the capabilities handshake, and starts
+// demuxing responses and events on a background goroutine
+func DialQMP(network, addr string) (*QMPClient, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial qmp: %s", err)
+	}
+
+	c := &QMPClient{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		pending: make(map[uint64]chan qmpResponse),
+		events:  make(chan Event, 16),
+		closed:  make(chan struct{}),
+	}
+	c.eventSubs = []chan Event{c.events}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+
+	// QEMU greets every new connection with a banner advertising its
+	// version and capabilities before any command can be issued
+	var banner struct {
+		QMP struct {
+			Version map[string]interface{} `json:"version"`
+		} `json:"QMP"`
+	}
+	if err := dec.Decode(&banner); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read qmp banner: %s", err)
+	}
+
+	go c.demux(dec)
+
+	if _, err := c.Execute(context.Background(), "qmp_capabilities", nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("qmp_capabilities: %s", err)
+	}
+
+	return c, nil
+}
+
+// demux reads every message off the wire, routing command replies to
+// the waiting Execute call and events to every subscriber (Events() and
+// any waitForEvent call in flight)
+func (c *QMPClient) demux(dec *json.Decoder) {
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			c.eventMu.Lock()
+			subs := c.eventSubs
+			c.eventSubs = nil
+			c.eventMu.Unlock()
+
+			for _, ch := range subs {
+				close(ch)
+			}
+
+			c.closeOnce.Do(func() { close(c.closed) })
+			return
+		}
+
+		var ev Event
+		if json.Unmarshal(raw, &ev) == nil && ev.Name != "" {
+			c.broadcastEvent(ev)
+			continue
+		}
+
+		var resp qmpResponse
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		if ok {
+			delete(c.pending, resp.ID)
+		}
+		c.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// broadcastEvent fans ev out to every current subscriber, dropping it
+// for subscribers whose buffer is full rather than blocking demux
+func (c *QMPClient) broadcastEvent(ev Event) {
+	c.eventMu.Lock()
+	subs := make([]chan Event, len(c.eventSubs))
+	copy(subs, c.eventSubs)
+	c.eventMu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribeEvents registers a private channel that receives every
+// event alongside Events(), so internal waiters like waitForEvent
+// never steal events from a caller draining Events() themselves. The
+// returned cancel func must be called once the subscriber is done
+func (c *QMPClient) subscribeEvents() (chan Event, func()) {
+	ch := make(chan Event, 16)
+
+	c.eventMu.Lock()
+	c.eventSubs = append(c.eventSubs, ch)
+	c.eventMu.Unlock()
+
+	cancel := func() {
+		c.eventMu.Lock()
+		defer c.eventMu.Unlock()
+
+		for i, sub := range c.eventSubs {
+			if sub == ch {
+				c.eventSubs = append(c.eventSubs[:i], c.eventSubs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+		// already removed and closed by demux's teardown on disconnect
+	}
+
+	return ch, cancel
+}
+
+// Execute dispatches a QMP command and blocks until the matching
+// response arrives, c is closed, or ctx is done, returning the raw
+// "return" payload
+func (c *QMPClient) Execute(ctx context.Context, cmd string, args interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan qmpResponse, 1)
+	c.pending[id] = ch
+	err := c.enc.Encode(qmpCommand{Execute: cmd, Arguments: args, ID: id})
+	c.mu.Unlock()
+
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s: %s", cmd, err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s: %s", cmd, resp.Error.Desc)
+		}
+		return resp.Return, nil
+	case <-c.closed:
+		return nil, fmt.Errorf("%s: qmp connection closed", cmd)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Events returns the channel on which asynchronous QMP events
+// (SHUTDOWN, RESET, STOP, POWERDOWN, ...) are delivered
+func (c *QMPClient) Events() <-chan Event {
+	return c.events
+}
+
+// Close tears down the underlying QMP connection
+func (c *QMPClient) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.conn.Close()
+}
+
+// waitForEvent blocks until an event named one of want arrives,
+// ctx is done, or the connection closes. It subscribes its own event
+// feed rather than reading Events(), so it never competes with a
+// caller that is draining Events() for its own event handling
+func (c *QMPClient) waitForEvent(ctx context.Context, want ...string) error {
+	ch, cancel := c.subscribeEvents()
+	defer cancel()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("qmp connection closed while waiting for event")
+			}
+			for _, name := range want {
+				if ev.Name == name {
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Monitor dials the machine's QMP socket, caching the client for
+// subsequent calls. Run must have been called first
+func (m *Machine) Monitor() (*QMPClient, error) {
+	if len(m.monitor) == 0 {
+		return nil, fmt.Errorf("machine has no monitor socket, call AddMonitorUnix first")
+	}
+
+	if m.qmp != nil {
+		return m.qmp, nil
+	}
+
+	client, err := DialQMP("unix", m.monitor)
+	if err != nil {
+		return nil, err
+	}
+
+	m.qmp = client
+	return client, nil
+}
+
+// SystemReset asks the guest to perform a hard reset via QMP
+func (m *Machine) SystemReset() error {
+	mon, err := m.Monitor()
+	if err != nil {
+		return err
+	}
+
+	_, err = mon.Execute(context.Background(), "system_reset", nil)
+	return err
+}
+
+// QueryStatus returns the current VM run state, e.g. "running",
+// "paused" or "shutdown"
+func (m *Machine) QueryStatus() (string, error) {
+	mon, err := m.Monitor()
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := mon.Execute(context.Background(), "query-status", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return "", fmt.Errorf("query-status: %s", err)
+	}
+
+	return status.Status, nil
+}
+
+// HumanMonitorCommand runs a human monitor protocol (HMP) command
+// and returns its text output, for functionality not exposed over QMP
+func (m *Machine) HumanMonitorCommand(cmd string) (string, error) {
+	mon, err := m.Monitor()
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := mon.Execute(context.Background(), "human-monitor-command", map[string]interface{}{
+		"command-line": cmd,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var out string
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("human-monitor-command: %s", err)
+	}
+
+	return out, nil
+}
+
+// Snapshot saves the current VM state (RAM and disk) under name via
+// the HMP savevm command, so it can later be restored with loadvm
+func (m *Machine) Snapshot(name string) error {
+	_, err := m.HumanMonitorCommand(fmt.Sprintf("savevm %s", name))
+	return err
+}
+
+// Shutdown asks the guest to power down cleanly over QMP and waits for
+// the SHUTDOWN event, falling back to SIGTERM on the process if ctx
+// expires first
+func (m *Machine) Shutdown(ctx context.Context) error {
+	mon, err := m.Monitor()
+	if err != nil {
+		return err
+	}
+
+	if _, err := mon.Execute(ctx, "system_powerdown", nil); err != nil {
+		return err
+	}
+
+	err = mon.waitForEvent(ctx, "SHUTDOWN")
+	if err == nil {
+		return nil
+	}
+
+	if m.proc != nil {
+		m.proc.Signal(syscall.SIGTERM)
+	}
+
+	return err
+}
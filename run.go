@@ -0,0 +1,263 @@
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pollInterval is how often Run polls for the monitor socket becoming
+// connectable while waiting for the machine to come up
+const pollInterval = 10 * time.Millisecond
+
+// shutdownGrace is how long Run waits for a graceful QMP powerdown
+// after ctx is cancelled before resorting to SIGTERM
+const shutdownGrace = 5 * time.Second
+
+// SerialMode selects where QEMU's serial console is redirected
+type SerialMode struct {
+	kind string
+	addr string
+}
+
+// SerialNone disables the serial console entirely
+var SerialNone = SerialMode{kind: "none"}
+
+// SerialStdio multiplexes the serial console onto the Instance's own
+// stdio, available through Instance.Serial
+var SerialStdio = SerialMode{kind: "stdio"}
+
+// SerialFile redirects the serial console to a file on disk
+func SerialFile(path string) SerialMode {
+	return SerialMode{kind: "file", addr: path}
+}
+
+// SerialTCP redirects the serial console to a TCP socket QEMU listens
+// on at addr
+func SerialTCP(addr string) SerialMode {
+	return SerialMode{kind: "tcp", addr: addr}
+}
+
+// SerialUnix redirects the serial console to a unix socket QEMU
+// listens on at path
+func SerialUnix(path string) SerialMode {
+	return SerialMode{kind: "unix", addr: path}
+}
+
+func (s SerialMode) arg() string {
+	switch s.kind {
+	case "none":
+		return "none"
+	case "stdio":
+		return "stdio"
+	case "file":
+		return fmt.Sprintf("file:%s", s.addr)
+	case "tcp":
+		return fmt.Sprintf("tcp:%s,server,nowait", s.addr)
+	case "unix":
+		return fmt.Sprintf("unix:%s,server,nowait", s.addr)
+	default:
+		return ""
+	}
+}
+
+// RunOptions configures a single Run invocation: the target
+// architecture/accelerator/machine type plus how the process's
+// console and stdio should be wired up
+type RunOptions struct {
+	Options
+
+	Serial    SerialMode // defaults to SerialNone
+	Nographic bool       // pass -nographic
+	ExtraArgs []string   // appended verbatim, for flags this package doesn't model
+}
+
+// Instance is a running QEMU process started through Run
+type Instance struct {
+	cmd *exec.Cmd
+
+	stdout io.Reader
+	stderr io.Reader
+	serial io.Reader
+
+	waitOnce sync.Once
+	waitErr  error
+	waitDone chan struct{}
+}
+
+// Stdout returns the process's standard output
+func (i *Instance) Stdout() io.Reader { return i.stdout }
+
+// Stderr returns the process's standard error
+func (i *Instance) Stderr() io.Reader { return i.stderr }
+
+// Serial returns the guest's serial console output. It is only backed
+// by this process's own stdio when RunOptions.Serial is SerialStdio;
+// for SerialFile/SerialTCP/SerialUnix the console lives in a file or
+// socket the caller addressed directly, so Serial reads as empty (EOF)
+// rather than exposing a nil io.Reader
+func (i *Instance) Serial() io.Reader { return i.serial }
+
+// Wait blocks until the QEMU process exits and returns its error, if
+// any. It is safe to call Wait from multiple goroutines
+func (i *Instance) Wait() error {
+	i.waitOnce.Do(func() {
+		i.waitErr = i.cmd.Wait()
+		close(i.waitDone)
+	})
+	<-i.waitDone
+	return i.waitErr
+}
+
+// Run launches the machine with the given RunOptions and returns once
+// it is either connectable over its monitor socket, has exited with
+// an error, or ctx has been cancelled. Cancelling ctx after Run
+// returns asks the guest to power down over QMP, falling back to
+// SIGTERM if it hasn't exited after shutdownGrace
+func (m *Machine) Run(ctx context.Context, opts RunOptions) (*Instance, error) {
+	qemu, args := m.buildArgs(opts.Options)
+
+	if opts.Nographic {
+		args = append(args, "-nographic")
+	}
+
+	if opts.Serial.kind == "" {
+		opts.Serial = SerialNone
+	}
+	args = append(args, "-serial", opts.Serial.arg())
+
+	args = append(args, opts.ExtraArgs...)
+
+	cmd := exec.Command(qemu, args...)
+	cmd.SysProcAttr = new(syscall.SysProcAttr)
+	cmd.SysProcAttr.Setsid = true
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	m.proc = cmd.Process
+
+	for _, iface := range m.ifaces {
+		if tap, ok := iface.(TapNet); ok {
+			if err := tap.attachBridge(); err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				return nil, err
+			}
+		}
+	}
+
+	inst := &Instance{
+		cmd:      cmd,
+		stdout:   stdout,
+		stderr:   stderr,
+		serial:   serialReader(opts.Serial, stdout),
+		waitDone: make(chan struct{}),
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- inst.Wait()
+		if len(m.tmpDir) > 0 {
+			os.RemoveAll(m.tmpDir)
+		}
+	}()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", qemu, err)
+		}
+		return inst, nil
+	case <-waitReady(ctx, m.monitor):
+	case <-ctx.Done():
+	}
+
+	go m.shutdownOnCancel(ctx, inst)
+
+	return inst, nil
+}
+
+// serialReader picks what Instance.Serial should expose for the given
+// mode: the shared stdio stream when multiplexed onto it, or an empty
+// reader when the console lives elsewhere and isn't tracked by this
+// process, so callers can always safely read from it
+func serialReader(mode SerialMode, stdout io.Reader) io.Reader {
+	if mode.kind == "stdio" {
+		return stdout
+	}
+	return emptyReader{}
+}
+
+// emptyReader is an io.Reader that immediately reports EOF, used in
+// place of a bare nil where Instance.Serial has nothing to read from
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// waitReady returns a channel that closes once addr is connectable,
+// polling every pollInterval until ctx is done. If addr is empty (no
+// monitor configured) it closes immediately, since there is nothing to
+// probe
+func waitReady(ctx context.Context, addr string) <-chan struct{} {
+	ready := make(chan struct{})
+
+	if len(addr) == 0 {
+		close(ready)
+		return ready
+	}
+
+	go func() {
+		defer close(ready)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				conn.Close()
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ready
+}
+
+// shutdownOnCancel waits for ctx to be cancelled, then asks the guest
+// to power down over QMP and falls back to SIGTERM if it hasn't
+// exited within shutdownGrace
+func (m *Machine) shutdownOnCancel(ctx context.Context, inst *Instance) {
+	<-ctx.Done()
+
+	grace, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	if err := m.Shutdown(grace); err == nil {
+		return
+	}
+
+	inst.cmd.Process.Signal(syscall.SIGTERM)
+}